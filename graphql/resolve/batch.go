@@ -0,0 +1,256 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package resolve
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/dgraph-io/dgraph/gql"
+	"github.com/dgraph-io/dgraph/graphql/schema"
+)
+
+// A ResolverGroup resolves every top level query in a single GraphQL
+// request together, so that implementations can coalesce sibling Dgraph
+// queries instead of running each one independently.
+type ResolverGroup interface {
+	ResolveAll(ctx context.Context, queries []schema.Query) []*Resolved
+}
+
+// batchable is implemented by QueryResolvers (currently just queryResolver)
+// that can hand over their rewritten gql.GraphQuery instead of executing it
+// themselves, so a batchedResolverGroup can merge it with its siblings.
+// httpResolver doesn't implement it, so custom HTTP fields are always
+// resolved independently, as before.
+type batchable interface {
+	QueryResolver
+	rewriteForBatch(ctx context.Context, query schema.Query) (*gql.GraphQuery, error)
+	batchExecutor() QueryExecutor
+}
+
+// batchResultsCtxKey carries the demultiplexed results of a batch execution
+// to the rewriteAndExecute call of each query in that batch, so it can
+// return its share of the already-executed merged query instead of issuing
+// its own Dgraph query.
+type batchResultsCtxKey struct{}
+
+func withBatchResults(ctx context.Context, results map[string]json.RawMessage) context.Context {
+	return context.WithValue(ctx, batchResultsCtxKey{}, results)
+}
+
+// batchResult returns the pre-fetched result for alias, if ctx carries one.
+func batchResult(ctx context.Context, alias string) ([]byte, bool) {
+	results, _ := ctx.Value(batchResultsCtxKey{}).(map[string]json.RawMessage)
+	if results == nil {
+		return nil, false
+	}
+	raw, ok := results[alias]
+	if !ok {
+		return nil, false
+	}
+	return []byte(raw), true
+}
+
+// RequestResolver resolves a whole GraphQL request - every one of its top
+// level queries - through group, running tracer.StartOperation/EndOperation
+// and any requestMiddlewares exactly once per request rather than once per
+// query.
+type RequestResolver struct {
+	group              ResolverGroup
+	tracer             Tracer
+	requestMiddlewares []RequestMiddleware
+}
+
+// A RequestResolverOpt sets an optional behaviour of a RequestResolver built
+// by NewRequestResolver.
+type RequestResolverOpt func(rr *RequestResolver)
+
+// WithRequestResolverTracer replaces the default OpenTracing Tracer with t.
+func WithRequestResolverTracer(t Tracer) RequestResolverOpt {
+	return func(rr *RequestResolver) {
+		rr.tracer = t
+	}
+}
+
+// WithRequestMiddlewares chains mws around the whole request, mws[0] running
+// outermost, for request-scoped concerns (e.g. logging or rejecting a
+// request before any field is resolved) that don't need to know which
+// query is being resolved.
+func WithRequestMiddlewares(mws ...RequestMiddleware) RequestResolverOpt {
+	return func(rr *RequestResolver) {
+		rr.requestMiddlewares = append(rr.requestMiddlewares, mws...)
+	}
+}
+
+// WithPersistedQueries installs NewPersistedQueryMiddleware(store) ahead of
+// any middlewares passed to WithRequestMiddlewares, so the built
+// RequestResolver applies the Apollo Automatic Persisted Queries protocol to
+// the request text carried on ctx by WithRequestText.  The middleware runs
+// inside ResolveAll, after the caller has parsed the queries it passes in:
+// it rejects an unknown or mismatched hash and registers new queries, but
+// the text it resolves is only exposed through ResolvedQueryText(ctx) and
+// does not replace those already parsed queries.
+func WithPersistedQueries(store PersistedQueryStore) RequestResolverOpt {
+	return func(rr *RequestResolver) {
+		rr.requestMiddlewares = append([]RequestMiddleware{NewPersistedQueryMiddleware(store)}, rr.requestMiddlewares...)
+	}
+}
+
+// NewRequestResolver builds a RequestResolver that resolves every query of a
+// request through group.
+func NewRequestResolver(group ResolverGroup, opts ...RequestResolverOpt) *RequestResolver {
+	rr := &RequestResolver{group: group}
+	for _, opt := range opts {
+		opt(rr)
+	}
+	if rr.tracer == nil {
+		rr.tracer = NewOpenTracingTracer()
+	}
+	return rr
+}
+
+// ResolveAll runs StartOperation, then requestMiddlewares, then group.ResolveAll
+// for every query in queries, then EndOperation - each exactly once for the
+// whole request.
+func (rr *RequestResolver) ResolveAll(ctx context.Context, queries []schema.Query) []*Resolved {
+	ctx = rr.tracer.StartOperation(ctx)
+	defer rr.tracer.EndOperation(ctx)
+
+	handler := chainRequest(func(ctx context.Context) ([]*Resolved, error) {
+		return rr.group.ResolveAll(ctx, queries), nil
+	}, rr.requestMiddlewares)
+
+	results, err := handler(ctx)
+	if err != nil {
+		results = make([]*Resolved, len(queries))
+		for i := range results {
+			results[i] = &Resolved{Err: err}
+		}
+	}
+	return results
+}
+
+// BatchingQueryExecutor merges a group of rewritten gql.GraphQuery ASTs -
+// one per alias - into a single multi-block Dgraph query, executes it once,
+// and demultiplexes the JSON response back into per-alias results.
+type BatchingQueryExecutor struct {
+	executor QueryExecutor
+}
+
+// NewBatchingQueryExecutor builds a BatchingQueryExecutor that issues its
+// merged queries through executor.
+func NewBatchingQueryExecutor(executor QueryExecutor) *BatchingQueryExecutor {
+	return &BatchingQueryExecutor{executor: executor}
+}
+
+// ExecuteBatch runs queries (keyed by the alias each was rewritten for) as
+// a single Dgraph query and returns each alias's slice of the response.
+func (be *BatchingQueryExecutor) ExecuteBatch(
+	ctx context.Context, queries map[string]*gql.GraphQuery) (map[string]json.RawMessage, error) {
+
+	if len(queries) == 0 {
+		return nil, nil
+	}
+
+	merged := &gql.GraphQuery{}
+	for alias, q := range queries {
+		q.Alias = alias
+		merged.Children = append(merged.Children, q)
+	}
+
+	resp, err := be.executor.Query(ctx, merged)
+	if err != nil {
+		return nil, err
+	}
+
+	var demuxed map[string]json.RawMessage
+	if err := json.Unmarshal(resp, &demuxed); err != nil {
+		return nil, schema.GQLWrapf(err, "couldn't demultiplex batched Dgraph response")
+	}
+	return demuxed, nil
+}
+
+// batchedResolverGroup is a ResolverGroup that merges the sibling queries
+// resolved by batchable resolvers into one Dgraph query, and falls back to
+// resolving every other query independently - e.g. a mix of ordinary
+// GraphQL queries and `@custom(http: ...)` fields in the same request.
+type batchedResolverGroup struct {
+	resolvers []QueryResolver
+}
+
+// NewBatchedResolverGroup builds a ResolverGroup that resolves queries[i]
+// with resolvers[i], coalescing whichever of them are batchable into a
+// single Dgraph query.
+func NewBatchedResolverGroup(resolvers []QueryResolver) ResolverGroup {
+	return &batchedResolverGroup{resolvers: resolvers}
+}
+
+func (g *batchedResolverGroup) ResolveAll(ctx context.Context, queries []schema.Query) []*Resolved {
+	results := make([]*Resolved, len(queries))
+
+	type batchEntry struct {
+		idx      int
+		resolver QueryResolver
+		query    schema.Query
+		alias    string
+	}
+	var batch []batchEntry
+	merged := make(map[string]*gql.GraphQuery)
+	var executor QueryExecutor
+
+	for i, q := range queries {
+		if i >= len(g.resolvers) || g.resolvers[i] == nil {
+			continue
+		}
+
+		b, ok := g.resolvers[i].(batchable)
+		if !ok {
+			results[i] = g.resolvers[i].Resolve(ctx, q)
+			continue
+		}
+
+		dgQuery, err := b.rewriteForBatch(ctx, q)
+		if err != nil {
+			results[i] = &Resolved{Err: err}
+			continue
+		}
+
+		alias := q.ResponseName()
+		merged[alias] = dgQuery
+		batch = append(batch, batchEntry{idx: i, resolver: b, query: q, alias: alias})
+		if executor == nil {
+			executor = b.batchExecutor()
+		}
+	}
+
+	if len(batch) == 0 {
+		return results
+	}
+
+	demuxed, err := NewBatchingQueryExecutor(executor).ExecuteBatch(ctx, merged)
+	for _, e := range batch {
+		if err != nil {
+			results[e.idx] = &Resolved{Err: schema.GQLWrapf(err, "Dgraph batched query failed")}
+			continue
+		}
+		// Resolve, not just Complete, so this query still runs through its
+		// field middleware chain and Tracer.
+		results[e.idx] = e.resolver.Resolve(withBatchResults(ctx, demuxed), e.query)
+	}
+
+	return results
+}
@@ -0,0 +1,109 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package resolve
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/dgraph-io/dgraph/gql"
+	"github.com/dgraph-io/dgraph/graphql/schema"
+)
+
+// TestBatchedResolverGroupRespectsFieldMiddleware is a regression test for a
+// bug where ResolveAll bypassed the field middleware chain entirely, so a
+// FieldMiddleware rejecting a query (e.g. an auth check) had no effect on
+// queries resolved through batching.
+func TestBatchedResolverGroupRespectsFieldMiddleware(t *testing.T) {
+	errForbidden := errors.New("forbidden")
+	rejectSecret := FieldMiddleware(func(ctx context.Context, query schema.Query, next QueryResolver) *Resolved {
+		if query.ResponseName() == "secret" {
+			return &Resolved{Err: errForbidden}
+		}
+		return next.Resolve(ctx, query)
+	})
+
+	executor := &fakeExecutor{response: []byte(`{"public":[{"ok":true}],"secret":[{"ok":true}]}`)}
+	resolver := NewQueryResolver(
+		&fakeRewriter{}, executor, &fakeCompleter{}, WithFieldMiddlewares(rejectSecret))
+
+	group := NewBatchedResolverGroup([]QueryResolver{resolver, resolver})
+	queries := []schema.Query{
+		&fakeQuery{name: "public"},
+		&fakeQuery{name: "secret"},
+	}
+
+	results := group.ResolveAll(context.Background(), queries)
+
+	if results[0].Err != nil {
+		t.Fatalf("expected public query to succeed, got err: %s", results[0].Err)
+	}
+	if results[1].Err != errForbidden {
+		t.Fatalf("expected secret query to be rejected by field middleware, got: %v", results[1].Err)
+	}
+}
+
+// TestBatchedResolverGroupDemuxesAliases checks that a batch of queries is
+// executed as a single Dgraph query, and that each query gets back only its
+// own alias's share of the merged response.
+func TestBatchedResolverGroupDemuxesAliases(t *testing.T) {
+	executor := &fakeExecutor{response: []byte(`{"a":[{"v":1}],"b":[{"v":2}]}`)}
+	resolver := NewQueryResolver(&fakeRewriter{}, executor, &fakeCompleter{})
+
+	group := NewBatchedResolverGroup([]QueryResolver{resolver, resolver})
+	queries := []schema.Query{
+		&fakeQuery{name: "a"},
+		&fakeQuery{name: "b"},
+	}
+
+	results := group.ResolveAll(context.Background(), queries)
+
+	if executor.calls != 1 {
+		t.Fatalf("expected the batch to run as a single Dgraph query, got %d calls", executor.calls)
+	}
+
+	var a, b []map[string]int
+	if err := json.Unmarshal(results[0].Data, &a); err != nil || a[0]["v"] != 1 {
+		t.Fatalf("expected alias a's own result, got %s (err %v)", results[0].Data, err)
+	}
+	if err := json.Unmarshal(results[1].Data, &b); err != nil || b[0]["v"] != 2 {
+		t.Fatalf("expected alias b's own result, got %s (err %v)", results[1].Data, err)
+	}
+}
+
+// TestBatchedResolverGroupDoesntRewriteTwice is a regression test for
+// rewriteAndExecute rewriting a query that had already been rewritten (and
+// executed) as part of the batch, wasting the work and risking a
+// non-idempotent Rewrite running twice.
+func TestBatchedResolverGroupDoesntRewriteTwice(t *testing.T) {
+	var rewrites int
+	rewriter := &fakeRewriter{rewrite: func(ctx context.Context, q schema.Query) (*gql.GraphQuery, error) {
+		rewrites++
+		return &gql.GraphQuery{Attr: q.(*fakeQuery).name}, nil
+	}}
+	executor := &fakeExecutor{response: []byte(`{"a":[{"v":1}]}`)}
+	resolver := NewQueryResolver(rewriter, executor, &fakeCompleter{})
+
+	group := NewBatchedResolverGroup([]QueryResolver{resolver})
+	group.ResolveAll(context.Background(), []schema.Query{&fakeQuery{name: "a"}})
+
+	if rewrites != 1 {
+		t.Fatalf("expected Rewrite to run exactly once (in rewriteForBatch), got %d calls", rewrites)
+	}
+}
@@ -0,0 +1,136 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package resolve
+
+import (
+	"github.com/dgraph-io/dgraph/graphql/schema"
+)
+
+// A ComplexityFunc computes the cost of a single field given the combined
+// complexity of its children, so that fields with a well known, non-linear
+// cost (e.g. a full text search) can override the default
+// max(childComplexity, 1) * max(first|limit, 1) calculation.
+type ComplexityFunc func(childComplexity int, args map[string]interface{}) int
+
+// ComplexityConfig bounds how expensive and how deep a single GraphQL query
+// is allowed to be before it's rewritten and shipped off to Dgraph.
+type ComplexityConfig struct {
+	// MaxDepth is the deepest a query's selection sets may nest.  Zero means
+	// no limit.
+	MaxDepth int
+	// ComplexityLimit is the highest total complexity, as computed by
+	// queryComplexity, that a query may have.  Zero means no limit.
+	ComplexityLimit int
+	// ComplexityFuncs lets individual fields (keyed by field name) override
+	// the default complexity calculation, mirroring gqlgen's
+	// complexityLimit extension.
+	ComplexityFuncs map[string]ComplexityFunc
+}
+
+// complexityError is returned when a query is rejected by a ComplexityConfig,
+// before it's ever rewritten to a Dgraph query.
+type complexityError struct {
+	msg string
+}
+
+func (e *complexityError) Error() string { return e.msg }
+
+// checkComplexity walks query's selection set and rejects it, returning a
+// GraphQL error, if it exceeds cfg's MaxDepth or ComplexityLimit.  It does
+// nothing if cfg is nil.
+func checkComplexity(cfg *ComplexityConfig, query schema.Query) error {
+	if cfg == nil {
+		return nil
+	}
+
+	// queryDepth counts query itself too; MaxDepth bounds only how deep the
+	// selection sets beneath it nest.
+	depth := queryDepth(query) - 1
+	if cfg.MaxDepth > 0 && depth > cfg.MaxDepth {
+		return schema.GQLWrapf(&complexityError{msg: "query too deep"},
+			"query has depth %d which exceeds the max depth of %d", depth, cfg.MaxDepth)
+	}
+
+	complexity := queryComplexity(cfg, query)
+	if cfg.ComplexityLimit > 0 && complexity > cfg.ComplexityLimit {
+		return schema.GQLWrapf(&complexityError{msg: "query too complex"},
+			"query has complexity %d which exceeds the max complexity of %d",
+			complexity, cfg.ComplexityLimit)
+	}
+
+	return nil
+}
+
+// queryDepth returns the depth of field's deepest nested selection, with a
+// field that selects nothing having depth 1.
+func queryDepth(field schema.Field) int {
+	max := 0
+	for _, child := range field.SelectionSet() {
+		if d := queryDepth(child); d > max {
+			max = d
+		}
+	}
+	return max + 1
+}
+
+// queryComplexity computes the complexity of field as the sum, over each of
+// its selections, of max(childComplexity, 1) * max(first|limit argument, 1),
+// unless cfg registers a ComplexityFunc for that field's name.
+func queryComplexity(cfg *ComplexityConfig, field schema.Field) int {
+	childComplexity := 0
+	for _, child := range field.SelectionSet() {
+		childComplexity += queryComplexity(cfg, child)
+	}
+
+	args := field.Arguments()
+	if fn, ok := cfg.ComplexityFuncs[field.Name()]; ok {
+		return fn(childComplexity, args)
+	}
+
+	if childComplexity < 1 {
+		childComplexity = 1
+	}
+	return childComplexity * max1(listSizeArg(args))
+}
+
+// listSizeArg returns the value of a `first` or `limit` argument, the two
+// names Dgraph's GraphQL layer uses to bound list results, or 1 if neither
+// is present.
+func listSizeArg(args map[string]interface{}) int {
+	for _, name := range []string{"first", "limit"} {
+		v, ok := args[name]
+		if !ok {
+			continue
+		}
+		switch n := v.(type) {
+		case int:
+			return n
+		case int64:
+			return int(n)
+		case float64:
+			return int(n)
+		}
+	}
+	return 1
+}
+
+func max1(n int) int {
+	if n < 1 {
+		return 1
+	}
+	return n
+}
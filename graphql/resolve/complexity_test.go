@@ -0,0 +1,92 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package resolve
+
+import (
+	"testing"
+
+	"github.com/dgraph-io/dgraph/graphql/schema"
+)
+
+func TestCheckComplexityNilConfigAllowsAnything(t *testing.T) {
+	deep := &fakeQuery{name: "q", children: []schema.Field{
+		&fakeQuery{name: "a", children: []schema.Field{&fakeQuery{name: "b"}}},
+	}}
+	if err := checkComplexity(nil, deep); err != nil {
+		t.Fatalf("expected no limit with a nil config, got: %s", err)
+	}
+}
+
+func TestCheckComplexityRejectsTooDeep(t *testing.T) {
+	query := &fakeQuery{name: "q", children: []schema.Field{
+		&fakeQuery{name: "a", children: []schema.Field{
+			&fakeQuery{name: "b", children: []schema.Field{&fakeQuery{name: "c"}}},
+		}},
+	}}
+
+	if err := checkComplexity(&ComplexityConfig{MaxDepth: 3}, query); err != nil {
+		t.Fatalf("expected depth 3 to be allowed by MaxDepth 3, got: %s", err)
+	}
+	if err := checkComplexity(&ComplexityConfig{MaxDepth: 2}, query); err == nil {
+		t.Fatalf("expected depth 3 to be rejected by MaxDepth 2")
+	}
+}
+
+func TestCheckComplexityRejectsTooComplex(t *testing.T) {
+	query := &fakeQuery{name: "q", children: []schema.Field{
+		&fakeQuery{name: "a", args: map[string]interface{}{"first": 10}},
+		&fakeQuery{name: "b", args: map[string]interface{}{"limit": 5}},
+	}}
+
+	cfg := &ComplexityConfig{ComplexityLimit: 20}
+	if err := checkComplexity(cfg, query); err != nil {
+		t.Fatalf("expected complexity 15 to be within limit 20, got: %s", err)
+	}
+
+	cfg = &ComplexityConfig{ComplexityLimit: 10}
+	if err := checkComplexity(cfg, query); err == nil {
+		t.Fatalf("expected complexity 15 to exceed limit 10")
+	}
+}
+
+func TestQueryComplexityUsesComplexityFuncOverride(t *testing.T) {
+	query := &fakeQuery{name: "search", args: map[string]interface{}{"first": 100}}
+
+	cfg := &ComplexityConfig{
+		ComplexityFuncs: map[string]ComplexityFunc{
+			"search": func(childComplexity int, args map[string]interface{}) int {
+				return 1000
+			},
+		},
+	}
+
+	if got := queryComplexity(cfg, query); got != 1000 {
+		t.Fatalf("expected the ComplexityFunc override to be used, got %d", got)
+	}
+}
+
+func TestListSizeArgPrefersFirstThenLimit(t *testing.T) {
+	if n := listSizeArg(map[string]interface{}{"first": 5, "limit": 50}); n != 5 {
+		t.Fatalf("expected first to take precedence, got %d", n)
+	}
+	if n := listSizeArg(map[string]interface{}{"limit": 50}); n != 50 {
+		t.Fatalf("expected limit to be used when first is absent, got %d", n)
+	}
+	if n := listSizeArg(map[string]interface{}{}); n != 1 {
+		t.Fatalf("expected 1 when neither is present, got %d", n)
+	}
+}
@@ -0,0 +1,222 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package resolve
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/tidwall/gjson"
+
+	"github.com/dgraph-io/dgraph/graphql/schema"
+)
+
+// parentValueCtxKey is how the parent object's resolved fields are threaded
+// through ctx to a httpResolver, so that a `@http` directive's url/body
+// templates can reference them (e.g. `{{.Parent.id}}`) alongside the
+// field's own arguments.
+type parentValueCtxKey struct{}
+
+// WithParentValue returns a context carrying parent as the object whose
+// field is about to be resolved by a httpResolver, for use in that
+// resolver's url/body templates.
+func WithParentValue(ctx context.Context, parent map[string]interface{}) context.Context {
+	return context.WithValue(ctx, parentValueCtxKey{}, parent)
+}
+
+func parentValue(ctx context.Context) map[string]interface{} {
+	p, _ := ctx.Value(parentValueCtxKey{}).(map[string]interface{})
+	return p
+}
+
+// RetryPolicy controls how a HTTPDataSourceConfig retries a failed remote
+// call, backing off exponentially between attempts.
+type RetryPolicy struct {
+	// MaxRetries is the number of retries after the initial attempt. Zero
+	// means the request is tried once and not retried.
+	MaxRetries int
+	// BaseBackoff is the delay before the first retry; it doubles on each
+	// subsequent retry.
+	BaseBackoff time.Duration
+}
+
+func (rp RetryPolicy) backoff(attempt int) time.Duration {
+	if rp.BaseBackoff <= 0 {
+		return 0
+	}
+	return rp.BaseBackoff * time.Duration(math.Pow(2, float64(attempt)))
+}
+
+// HTTPDataSourceConfig is the resolver-side configuration built from a
+// field's `@http(...)` directive: which headers to forward and add, how to
+// retry, and how to map the remote response back into the field's type.
+type HTTPDataSourceConfig struct {
+	// ForwardHeaders lists inbound request header names that should be
+	// copied onto the outgoing remote request.
+	ForwardHeaders []string
+	// Headers are added to every outgoing remote request, e.g. a static
+	// Authorization header for the remote service.
+	Headers map[string]string
+	// Retry bounds how many times, and how fast, a failed call is retried.
+	Retry RetryPolicy
+	// ResponseMapping is a GJSON path used to pick the subtree of the
+	// remote JSON response that should be coerced into the field's type.
+	// Empty means the whole response body is used as-is.
+	ResponseMapping string
+	// Timeout bounds how long a single attempt at the remote call is given
+	// before it's treated as failed (and retried, subject to Retry). Zero
+	// means no timeout is applied beyond whatever deadline ctx already
+	// carries.
+	Timeout time.Duration
+}
+
+// inboundHeadersCtxKey is how the resolver learns about the headers that
+// came in on the original GraphQL request, so ForwardHeaders has something
+// to copy from.
+type inboundHeadersCtxKey struct{}
+
+// WithInboundHeaders returns a context carrying the headers of the original
+// GraphQL request, for a httpResolver's ForwardHeaders to copy from.
+func WithInboundHeaders(ctx context.Context, headers map[string][]string) context.Context {
+	return context.WithValue(ctx, inboundHeadersCtxKey{}, headers)
+}
+
+func inboundHeaders(ctx context.Context) map[string][]string {
+	h, _ := ctx.Value(inboundHeadersCtxKey{}).(map[string][]string)
+	return h
+}
+
+// templateKind says which syntactic context a renderTemplate call's output
+// is substituted into, so values can be escaped appropriately: a URL or a
+// JSON request body.
+type templateKind int
+
+const (
+	templateKindURL templateKind = iota
+	templateKindJSON
+)
+
+// templateFuncs are available to a `@http` directive's url/body templates
+// for explicitly escaping a value, e.g. `{{.Args.name | json}}` to embed an
+// argument inside a larger JSON fragment it's building up itself.
+var templateFuncs = template.FuncMap{
+	"json":     jsonEscape,
+	"urlquery": urlQueryEscape,
+}
+
+// urlQueryEscape query-escapes the string form of v, unwrapping the
+// escapedValue a template argument is held in.
+func urlQueryEscape(v interface{}) string {
+	if e, ok := v.(escapedValue); ok {
+		v = e.v
+	}
+	return url.QueryEscape(fmt.Sprintf("%v", v))
+}
+
+// jsonEscape renders v as a JSON value and, if that value is a JSON string,
+// strips the surrounding quotes - callers substitute the result inside a
+// template's own `"..."` literal (e.g. `"name": "{{.Args.name | json}}"`),
+// so only the escaped contents, not a second pair of quotes, are wanted.
+func jsonEscape(v interface{}) string {
+	if e, ok := v.(escapedValue); ok {
+		v = e.v
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+	if len(b) >= 2 && b[0] == '"' && b[len(b)-1] == '"' {
+		return string(b[1 : len(b)-1])
+	}
+	return string(b)
+}
+
+// escapedValue wraps a raw argument/parent value so that a plain
+// `{{.Args.x}}`/`{{.Parent.x}}` - not just one explicitly piped through
+// templateFuncs - renders already escaped for kind.  Without this, a string
+// argument containing a `"` or `\` would corrupt the JSON body or URL it's
+// being substituted into.
+type escapedValue struct {
+	v    interface{}
+	kind templateKind
+}
+
+func (e escapedValue) String() string {
+	switch e.kind {
+	case templateKindJSON:
+		return jsonEscape(e.v)
+	default:
+		return url.QueryEscape(fmt.Sprintf("%v", e.v))
+	}
+}
+
+func escapeValues(m map[string]interface{}, kind templateKind) map[string]interface{} {
+	escaped := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		escaped[k] = escapedValue{v: v, kind: kind}
+	}
+	return escaped
+}
+
+// renderTemplate interpolates a Go text/template (as used in a `@http`
+// directive's url/body) against the field's arguments (`.Args`) and the
+// parent object's already-resolved fields (`.Parent`), escaping substituted
+// values for kind so that e.g. a quote in a string argument can't break out
+// of the JSON body or URL being built.
+func renderTemplate(
+	ctx context.Context, query schema.Query, tmpl string, kind templateKind) (string, error) {
+
+	if !strings.Contains(tmpl, "{{") {
+		return tmpl, nil
+	}
+
+	t, err := template.New(query.ResponseName()).Funcs(templateFuncs).Parse(tmpl)
+	if err != nil {
+		return "", schema.GQLWrapf(err, "couldn't parse @http template for %s", query.ResponseName())
+	}
+
+	data := struct {
+		Args   map[string]interface{}
+		Parent map[string]interface{}
+	}{
+		Args:   escapeValues(query.Arguments(), kind),
+		Parent: escapeValues(parentValue(ctx), kind),
+	}
+
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", schema.GQLWrapf(err, "couldn't render @http template for %s", query.ResponseName())
+	}
+	return buf.String(), nil
+}
+
+// applyResponseMapping extracts the configured subtree of a remote JSON
+// response, so that e.g. a field of type `[Review]` can be backed by an
+// endpoint that wraps its results in `{"data": {"reviews": [...]}}`.
+func applyResponseMapping(cfg *HTTPDataSourceConfig, body []byte) []byte {
+	if cfg == nil || cfg.ResponseMapping == "" {
+		return body
+	}
+	return []byte(gjson.GetBytes(body, cfg.ResponseMapping).Raw)
+}
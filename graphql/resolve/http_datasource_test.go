@@ -0,0 +1,143 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package resolve
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/dgraph/graphql/schema"
+)
+
+// TestRenderTemplateEscapesJSONBody is a regression test for a bug where a
+// quote or backslash in a string argument broke out of the JSON body a
+// `@http` directive's template was building.
+func TestRenderTemplateEscapesJSONBody(t *testing.T) {
+	query := &fakeQuery{name: "q", args: map[string]interface{}{
+		"name": `x", "admin":true, "y":"`,
+	}}
+
+	rendered, err := renderTemplate(
+		context.Background(), query, `{"name": "{{.Args.name}}"}`, templateKindJSON)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	var decoded map[string]interface{}
+	if err := json.Unmarshal([]byte(rendered), &decoded); err != nil {
+		t.Fatalf("rendered body isn't valid JSON: %s\nbody: %s", err, rendered)
+	}
+	if decoded["name"] != `x", "admin":true, "y":"` || len(decoded) != 1 {
+		t.Fatalf("expected the argument to stay a single string field, got %#v", decoded)
+	}
+}
+
+// TestRenderTemplateEscapesURL checks an ampersand or equals sign in an
+// argument can't corrupt a URL template's query string.
+func TestRenderTemplateEscapesURL(t *testing.T) {
+	query := &fakeQuery{name: "q", args: map[string]interface{}{
+		"q": "a&b=c",
+	}}
+
+	rendered, err := renderTemplate(
+		context.Background(), query, `http://example.com/search?q={{.Args.q}}`, templateKindURL)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if strings.Contains(rendered, "a&b=c") {
+		t.Fatalf("expected the argument to be URL escaped, got %s", rendered)
+	}
+	if !strings.Contains(rendered, "a%26b%3Dc") {
+		t.Fatalf("expected a%%26b%%3Dc in rendered URL, got %s", rendered)
+	}
+}
+
+func httpResolverFor(t *testing.T, srv *httptest.Server, cfg *HTTPDataSourceConfig) QueryResolver {
+	t.Helper()
+	return NewHTTPResolver(srv.Client(),
+		&fakeRewriter{}, nil, &fakeCompleter{}, WithHTTPDataSource(cfg))
+}
+
+func queryFor(method, path string) *fakeQuery {
+	return &fakeQuery{name: "q", hrc: &schema.HTTPResolverConfig{Method: method, URL: path}}
+}
+
+// TestHTTPResolverRetriesOn5xxNotOn4xx checks doWithRetry's backoff loop
+// retries a failing remote call only when it's worth retrying.
+func TestHTTPResolverRetriesOn5xxNotOn4xx(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	resolver := httpResolverFor(t, srv, &HTTPDataSourceConfig{
+		Retry: RetryPolicy{MaxRetries: 2},
+	})
+	resolver.Resolve(context.Background(), queryFor(http.MethodGet, srv.URL))
+
+	if got := atomic.LoadInt32(&calls); got != 3 {
+		t.Fatalf("expected 1 initial attempt + 2 retries on 5xx, got %d calls", got)
+	}
+
+	atomic.StoreInt32(&calls, 0)
+	srv2 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer srv2.Close()
+
+	resolver2 := httpResolverFor(t, srv2, &HTTPDataSourceConfig{
+		Retry: RetryPolicy{MaxRetries: 2},
+	})
+	resolver2.Resolve(context.Background(), queryFor(http.MethodGet, srv2.URL))
+
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Fatalf("expected no retries on 4xx, got %d calls", got)
+	}
+}
+
+// TestHTTPResolverAppliesPerAttemptTimeout is a regression test for ctx
+// being forwarded to the remote call unbounded: a slow endpoint should time
+// out per HTTPDataSourceConfig.Timeout rather than hang indefinitely.
+func TestHTTPResolverAppliesPerAttemptTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	defer srv.Close()
+
+	resolver := httpResolverFor(t, srv, &HTTPDataSourceConfig{Timeout: 10 * time.Millisecond})
+
+	start := time.Now()
+	resolved := resolver.Resolve(context.Background(), queryFor(http.MethodGet, srv.URL))
+	if time.Since(start) > 500*time.Millisecond {
+		t.Fatalf("expected the call to time out quickly, took %s", time.Since(start))
+	}
+	if resolved.Err == nil {
+		t.Fatalf("expected a timeout error")
+	}
+}
@@ -0,0 +1,136 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package resolve
+
+import (
+	"context"
+
+	otrace "go.opencensus.io/trace"
+
+	"github.com/dgraph-io/dgraph/graphql/schema"
+)
+
+// A RequestHandler runs every top level query of a whole GraphQL request and
+// returns their results, or a non-nil error if the request as a whole
+// couldn't be resolved before any individual query was attempted.
+type RequestHandler func(ctx context.Context) ([]*Resolved, error)
+
+// A RequestMiddleware wraps a whole request, getting a chance to run code
+// before and after next runs - for example request-scoped logging or
+// rejecting a request before any field is resolved.
+type RequestMiddleware func(ctx context.Context, next RequestHandler) ([]*Resolved, error)
+
+// A FieldMiddleware wraps the resolution of a single query field, getting a
+// chance to run code before and after next.Resolve runs - for example an
+// auth check that only some fields require.
+type FieldMiddleware func(ctx context.Context, query schema.Query, next QueryResolver) *Resolved
+
+// A Tracer is notified as a request, and each field within it, starts and
+// finishes, so that implementations can record per-field timings (as Apollo
+// Tracing does in `extensions.tracing`) without the core resolvers knowing
+// anything about tracing.
+type Tracer interface {
+	// StartOperation is called once, before any field in the request is
+	// resolved, and returns a context carrying whatever state the tracer
+	// needs to later compute the request's total duration.
+	StartOperation(ctx context.Context) context.Context
+	// EndOperation is called once the whole request has been resolved.
+	EndOperation(ctx context.Context)
+	// StartField is called before query is resolved and returns a context
+	// carrying whatever state the tracer needs to later compute that
+	// field's duration.
+	StartField(ctx context.Context, query schema.Query) context.Context
+	// EndField is called once query has been resolved, with the error (if
+	// any) that resolving it produced.
+	EndField(ctx context.Context, query schema.Query, err error)
+}
+
+// chainFields composes mws around core, in order, so that mws[0] runs
+// outermost.  It replaces the current ad hoc approach of resolvers each
+// calling otrace.FromContext/x.SpanTimer themselves - that behaviour is now
+// just the default Tracer, applied through this same chain.
+func chainFields(core QueryResolver, mws []FieldMiddleware) QueryResolver {
+	chained := core
+	for i := len(mws) - 1; i >= 0; i-- {
+		mw := mws[i]
+		next := chained
+		chained = QueryResolverFunc(func(ctx context.Context, query schema.Query) *Resolved {
+			return mw(ctx, query, next)
+		})
+	}
+	return chained
+}
+
+// chainRequest composes mws around core, in order, so that mws[0] runs
+// outermost - the RequestMiddleware equivalent of chainFields.
+func chainRequest(core RequestHandler, mws []RequestMiddleware) RequestHandler {
+	chained := core
+	for i := len(mws) - 1; i >= 0; i-- {
+		mw := mws[i]
+		next := chained
+		chained = func(ctx context.Context) ([]*Resolved, error) {
+			return mw(ctx, next)
+		}
+	}
+	return chained
+}
+
+// tracingMiddleware adapts a Tracer into a FieldMiddleware, so it can be
+// spliced into the same chain as any other field middleware.
+func tracingMiddleware(tracer Tracer) FieldMiddleware {
+	return func(ctx context.Context, query schema.Query, next QueryResolver) *Resolved {
+		ctx = tracer.StartField(ctx, query)
+		resolved := next.Resolve(ctx, query)
+		tracer.EndField(ctx, query, resolved.Err)
+		return resolved
+	}
+}
+
+// openTracingTracer is the built-in Tracer used when none is configured.  It
+// reproduces the OpenCensus span-per-field behaviour the resolvers used to
+// implement themselves with otrace.FromContext and x.SpanTimer.
+type openTracingTracer struct{}
+
+// NewOpenTracingTracer builds a Tracer that records one OpenCensus span per
+// operation and per resolved field, using the span already present on ctx
+// (as set up by the HTTP entry point) as the parent.
+func NewOpenTracingTracer() Tracer {
+	return openTracingTracer{}
+}
+
+func (openTracingTracer) StartOperation(ctx context.Context) context.Context {
+	ctx, _ = otrace.StartSpan(ctx, "graphql.operation")
+	return ctx
+}
+
+func (openTracingTracer) EndOperation(ctx context.Context) {
+	otrace.FromContext(ctx).End()
+}
+
+func (openTracingTracer) StartField(ctx context.Context, query schema.Query) context.Context {
+	ctx, span := otrace.StartSpan(ctx, "resolve."+query.ResponseName())
+	span.Annotatef(nil, "resolving field %s", query.ResponseName())
+	return ctx
+}
+
+func (openTracingTracer) EndField(ctx context.Context, query schema.Query, err error) {
+	span := otrace.FromContext(ctx)
+	if err != nil {
+		span.Annotatef(nil, "field %s failed: %s", query.ResponseName(), err)
+	}
+	span.End()
+}
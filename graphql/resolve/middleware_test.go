@@ -0,0 +1,120 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package resolve
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/dgraph-io/dgraph/graphql/schema"
+)
+
+// recordingTracer counts its StartOperation/EndOperation/StartField/EndField
+// calls, so tests can assert the operation-level hooks are actually wired
+// in, not just the per-field ones.
+type recordingTracer struct {
+	operationStarts, operationEnds int
+	fieldStarts, fieldEnds         int
+}
+
+func (t *recordingTracer) StartOperation(ctx context.Context) context.Context {
+	t.operationStarts++
+	return ctx
+}
+
+func (t *recordingTracer) EndOperation(ctx context.Context) {
+	t.operationEnds++
+}
+
+func (t *recordingTracer) StartField(ctx context.Context, query schema.Query) context.Context {
+	t.fieldStarts++
+	return ctx
+}
+
+func (t *recordingTracer) EndField(ctx context.Context, query schema.Query, err error) {
+	t.fieldEnds++
+}
+
+// TestRequestResolverCallsTracerOperationHooksOnce is a regression test for
+// StartOperation/EndOperation running once per top level field instead of
+// once per request: a request with two fields must still only start and end
+// one operation.
+func TestRequestResolverCallsTracerOperationHooksOnce(t *testing.T) {
+	tracer := &recordingTracer{}
+	resolver := NewQueryResolver(
+		&fakeRewriter{}, &fakeExecutor{response: []byte(`{}`)}, &fakeCompleter{}, WithTracer(tracer))
+
+	group := NewBatchedResolverGroup([]QueryResolver{resolver, resolver})
+	rr := NewRequestResolver(group, WithRequestResolverTracer(tracer))
+
+	rr.ResolveAll(context.Background(), []schema.Query{&fakeQuery{name: "a"}, &fakeQuery{name: "b"}})
+
+	if tracer.operationStarts != 1 || tracer.operationEnds != 1 {
+		t.Fatalf("expected StartOperation/EndOperation to run once for the whole request, got starts=%d ends=%d",
+			tracer.operationStarts, tracer.operationEnds)
+	}
+	if tracer.fieldStarts != 2 || tracer.fieldEnds != 2 {
+		t.Fatalf("expected StartField/EndField to run once per field, got starts=%d ends=%d",
+			tracer.fieldStarts, tracer.fieldEnds)
+	}
+}
+
+func TestRequestResolverRunsRequestMiddleware(t *testing.T) {
+	errRejected := errors.New("rejected by request middleware")
+	reject := RequestMiddleware(func(ctx context.Context, next RequestHandler) ([]*Resolved, error) {
+		return nil, errRejected
+	})
+
+	resolver := NewQueryResolver(&fakeRewriter{}, &fakeExecutor{response: []byte(`{}`)}, &fakeCompleter{})
+	group := NewBatchedResolverGroup([]QueryResolver{resolver})
+	rr := NewRequestResolver(group, WithRequestMiddlewares(reject))
+
+	results := rr.ResolveAll(context.Background(), []schema.Query{&fakeQuery{name: "q"}})
+
+	if len(results) != 1 || results[0].Err != errRejected {
+		t.Fatalf("expected request middleware to reject the request, got: %+v", results)
+	}
+}
+
+func TestQueryResolverFieldMiddlewareOrder(t *testing.T) {
+	var order []string
+	trace := func(name string) FieldMiddleware {
+		return func(ctx context.Context, query schema.Query, next QueryResolver) *Resolved {
+			order = append(order, name+":before")
+			resolved := next.Resolve(ctx, query)
+			order = append(order, name+":after")
+			return resolved
+		}
+	}
+
+	resolver := NewQueryResolver(
+		&fakeRewriter{}, &fakeExecutor{response: []byte(`{}`)}, &fakeCompleter{},
+		WithFieldMiddlewares(trace("outer"), trace("inner")))
+
+	resolver.Resolve(context.Background(), &fakeQuery{name: "q"})
+
+	want := []string{"outer:before", "inner:before", "inner:after", "outer:after"}
+	if len(order) != len(want) {
+		t.Fatalf("got order %v, want %v", order, want)
+	}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("got order %v, want %v", order, want)
+		}
+	}
+}
@@ -0,0 +1,265 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package resolve
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sync"
+
+	"github.com/dgraph-io/dgraph/graphql/schema"
+)
+
+// ErrPersistedQueryNotFound is returned (and translated into the
+// "PersistedQueryNotFound" GraphQL error) when a client sends only the hash
+// of a query and that hash isn't yet known to the PersistedQueryStore.  Per
+// the Apollo Automatic Persisted Queries protocol, the client is expected to
+// retry the request with the hash and the full query text so it can be
+// registered.
+var ErrPersistedQueryNotFound = errors.New("PersistedQueryNotFound")
+
+// ErrPersistedQueryMismatch is returned when a client supplies both a query
+// and a hash, but the hash doesn't match the SHA-256 of the query.
+var ErrPersistedQueryMismatch = errors.New("provided sha does not match query")
+
+// PersistedQueryExtensions is the shape of the `extensions.persistedQuery`
+// field that Apollo Client attaches to APQ requests.
+type PersistedQueryExtensions struct {
+	Version    int    `json:"version"`
+	Sha256Hash string `json:"sha256Hash"`
+}
+
+// Extensions is the top level `extensions` object of a GraphQL request, as
+// used by the Automatic Persisted Queries protocol.
+type Extensions struct {
+	PersistedQuery *PersistedQueryExtensions `json:"persistedQuery,omitempty"`
+}
+
+// A PersistedQueryStore records the mapping from a query's SHA-256 hash to
+// its full text, so that subsequent requests can send just the hash.
+// Implementations must be safe for concurrent use.
+type PersistedQueryStore interface {
+	// Get returns the query text registered for hash, and whether it was
+	// found.
+	Get(ctx context.Context, hash string) (string, bool)
+	// Put registers query text against hash.
+	Put(ctx context.Context, hash string, query string) error
+}
+
+// QueryHash returns the hex encoded SHA-256 hash of query, as used to key a
+// PersistedQueryStore.
+func QueryHash(query string) string {
+	sum := sha256.Sum256([]byte(query))
+	return hex.EncodeToString(sum[:])
+}
+
+// requestTextCtxKey is how the raw `query` string and `extensions` of the
+// incoming HTTP request are threaded through ctx, so a
+// NewPersistedQueryMiddleware has something to resolve before the request
+// reaches a QueryResolver.
+type requestTextCtxKey struct{}
+
+type requestText struct {
+	query string
+	ext   *PersistedQueryExtensions
+}
+
+// WithRequestText returns a context carrying the query text (empty for an
+// APQ hash-only request) and persistedQuery extensions of the incoming
+// request, for a NewPersistedQueryMiddleware to resolve.
+func WithRequestText(ctx context.Context, query string, ext *PersistedQueryExtensions) context.Context {
+	return context.WithValue(ctx, requestTextCtxKey{}, requestText{query: query, ext: ext})
+}
+
+// resolvedQueryTextCtxKey is how NewPersistedQueryMiddleware hands the
+// resolved query text - looked up from store if the request only carried a
+// hash - back to whatever parses it into a schema.Query.
+type resolvedQueryTextCtxKey struct{}
+
+// ResolvedQueryText returns the query text NewPersistedQueryMiddleware
+// resolved for this request, or "" if no persisted query middleware ran.
+func ResolvedQueryText(ctx context.Context) string {
+	q, _ := ctx.Value(resolvedQueryTextCtxKey{}).(string)
+	return q
+}
+
+// NewPersistedQueryMiddleware builds a RequestMiddleware that applies the
+// Automatic Persisted Queries protocol to the request carried on ctx by
+// WithRequestText: a hash-only request is resolved against store (replying
+// with the PersistedQueryNotFound GraphQL error, via next never being
+// called, if store doesn't recognise the hash), and a request carrying both
+// a query and a hash registers that query in store for later requests.
+//
+// Installed through WithPersistedQueries, this middleware runs inside
+// RequestResolver.ResolveAll, after the request's queries have already been
+// parsed into schema.Query values; next sees the resolved text through
+// ResolvedQueryText(ctx), and the parsed queries are not replaced by it.
+func NewPersistedQueryMiddleware(store PersistedQueryStore) RequestMiddleware {
+	return func(ctx context.Context, next RequestHandler) ([]*Resolved, error) {
+		req, _ := ctx.Value(requestTextCtxKey{}).(requestText)
+
+		resolved, err := ResolvePersistedQuery(ctx, store, req.query, req.ext)
+		if err != nil {
+			return nil, schema.GQLWrapf(err, "persisted query")
+		}
+
+		return next(context.WithValue(ctx, resolvedQueryTextCtxKey{}, resolved))
+	}
+}
+
+// ResolvePersistedQuery applies the APQ protocol to a single request: given
+// the query text that accompanied the request (empty if the client only sent
+// a hash) and the persistedQuery extensions, it returns the query text that
+// should actually be executed.
+//
+// If the client sent a hash with no query, the hash is looked up in store;
+// ErrPersistedQueryNotFound is returned if it's not known.  If the client
+// sent both a query and a hash, the hash is verified and, if correct, the
+// query is registered in store for later requests.
+func ResolvePersistedQuery(
+	ctx context.Context,
+	store PersistedQueryStore,
+	query string,
+	ext *PersistedQueryExtensions) (string, error) {
+
+	if ext == nil || ext.Sha256Hash == "" {
+		return query, nil
+	}
+
+	if query == "" {
+		q, ok := store.Get(ctx, ext.Sha256Hash)
+		if !ok {
+			return "", ErrPersistedQueryNotFound
+		}
+		return q, nil
+	}
+
+	if QueryHash(query) != ext.Sha256Hash {
+		return "", ErrPersistedQueryMismatch
+	}
+
+	if err := store.Put(ctx, ext.Sha256Hash, query); err != nil {
+		return "", schema.GQLWrapf(err, "couldn't persist query")
+	}
+	return query, nil
+}
+
+// lruPersistedQueryStore is an in-memory, size-bounded PersistedQueryStore.
+// Entries are evicted least-recently-used first once the store holds more
+// than maxSize entries.
+type lruPersistedQueryStore struct {
+	mu      sync.Mutex
+	maxSize int
+	ll      *list.List
+	items   map[string]*list.Element
+}
+
+type lruEntry struct {
+	hash  string
+	query string
+}
+
+// NewInMemoryPersistedQueryStore builds a PersistedQueryStore backed by an
+// in-process LRU cache holding at most maxSize queries.  It's the default
+// store used when no other PersistedQueryStore is configured; hashes don't
+// survive an Alpha restart.
+func NewInMemoryPersistedQueryStore(maxSize int) PersistedQueryStore {
+	if maxSize <= 0 {
+		maxSize = 1000
+	}
+	return &lruPersistedQueryStore{
+		maxSize: maxSize,
+		ll:      list.New(),
+		items:   make(map[string]*list.Element),
+	}
+}
+
+func (s *lruPersistedQueryStore) Get(ctx context.Context, hash string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.items[hash]
+	if !ok {
+		return "", false
+	}
+	s.ll.MoveToFront(e)
+	return e.Value.(*lruEntry).query, true
+}
+
+func (s *lruPersistedQueryStore) Put(ctx context.Context, hash string, query string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e, ok := s.items[hash]; ok {
+		s.ll.MoveToFront(e)
+		e.Value.(*lruEntry).query = query
+		return nil
+	}
+
+	e := s.ll.PushFront(&lruEntry{hash: hash, query: query})
+	s.items[hash] = e
+
+	if s.ll.Len() > s.maxSize {
+		oldest := s.ll.Back()
+		if oldest != nil {
+			s.ll.Remove(oldest)
+			delete(s.items, oldest.Value.(*lruEntry).hash)
+		}
+	}
+
+	return nil
+}
+
+// A PersistedQueryExecutor is the slice of QueryExecutor that the Dgraph
+// backed PersistedQueryStore needs in order to keep persisted queries in
+// Dgraph itself, so that they survive Alpha restarts and are shared across
+// all Alphas in the cluster.
+type PersistedQueryExecutor interface {
+	// Lookup returns the query text stored for hash, if any.
+	Lookup(ctx context.Context, hash string) (string, bool, error)
+	// Store records query text against hash.
+	Store(ctx context.Context, hash string, query string) error
+}
+
+// dgraphPersistedQueryStore is a PersistedQueryStore that keeps its
+// hash -> query mapping in Dgraph via a PersistedQueryExecutor, so that
+// registered queries survive Alpha restarts and are visible cluster-wide.
+type dgraphPersistedQueryStore struct {
+	executor PersistedQueryExecutor
+}
+
+// NewDgraphPersistedQueryStore builds a PersistedQueryStore that persists
+// its hash -> query mapping in Dgraph through executor, rather than keeping
+// it only in the memory of a single Alpha.
+func NewDgraphPersistedQueryStore(executor PersistedQueryExecutor) PersistedQueryStore {
+	return &dgraphPersistedQueryStore{executor: executor}
+}
+
+func (s *dgraphPersistedQueryStore) Get(ctx context.Context, hash string) (string, bool) {
+	query, ok, err := s.executor.Lookup(ctx, hash)
+	if err != nil {
+		return "", false
+	}
+	return query, ok
+}
+
+func (s *dgraphPersistedQueryStore) Put(ctx context.Context, hash string, query string) error {
+	return s.executor.Store(ctx, hash, query)
+}
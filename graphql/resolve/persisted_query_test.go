@@ -0,0 +1,110 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package resolve
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestInMemoryPersistedQueryStoreEvictsLeastRecentlyUsed(t *testing.T) {
+	store := NewInMemoryPersistedQueryStore(2)
+
+	store.Put(context.Background(), "a", "queryA")
+	store.Put(context.Background(), "b", "queryB")
+
+	// touch "a" so "b" becomes the least recently used entry.
+	if _, ok := store.Get(context.Background(), "a"); !ok {
+		t.Fatalf("expected a to be present")
+	}
+
+	store.Put(context.Background(), "c", "queryC")
+
+	if _, ok := store.Get(context.Background(), "b"); ok {
+		t.Fatalf("expected b to have been evicted")
+	}
+	if q, ok := store.Get(context.Background(), "a"); !ok || q != "queryA" {
+		t.Fatalf("expected a to survive eviction, got %q, %v", q, ok)
+	}
+	if q, ok := store.Get(context.Background(), "c"); !ok || q != "queryC" {
+		t.Fatalf("expected c to be present, got %q, %v", q, ok)
+	}
+}
+
+// TestPersistedQueryMiddlewareRoundTrip is a regression test for
+// ResolvePersistedQuery having no caller anywhere in the series: it drives
+// NewPersistedQueryMiddleware through the full APQ round trip a client
+// actually performs.
+func TestPersistedQueryMiddlewareRoundTrip(t *testing.T) {
+	store := NewInMemoryPersistedQueryStore(10)
+	mw := NewPersistedQueryMiddleware(store)
+
+	query := `{ me { name } }`
+	hash := QueryHash(query)
+	ext := &PersistedQueryExtensions{Sha256Hash: hash}
+
+	var resolvedText string
+	next := RequestHandler(func(ctx context.Context) ([]*Resolved, error) {
+		resolvedText = ResolvedQueryText(ctx)
+		return []*Resolved{{Data: []byte(`{}`)}}, nil
+	})
+
+	// 1. Client sends just the hash; store doesn't know it yet.
+	ctx := WithRequestText(context.Background(), "", ext)
+	_, err := mw(ctx, next)
+	if err == nil || !strings.Contains(err.Error(), ErrPersistedQueryNotFound.Error()) {
+		t.Fatalf("expected ErrPersistedQueryNotFound, got %v", err)
+	}
+
+	// 2. Client retries with the hash and the full query text; it should be
+	// registered and resolved.
+	ctx = WithRequestText(context.Background(), query, ext)
+	if _, err = mw(ctx, next); err != nil {
+		t.Fatalf("expected query+hash request to succeed, got %s", err)
+	}
+	if resolvedText != query {
+		t.Fatalf("expected resolved query text %q, got %q", query, resolvedText)
+	}
+
+	// 3. A later request with just the hash now hits the registered query.
+	resolvedText = ""
+	ctx = WithRequestText(context.Background(), "", ext)
+	if _, err = mw(ctx, next); err != nil {
+		t.Fatalf("expected hash-only request to hit cache, got %s", err)
+	}
+	if resolvedText != query {
+		t.Fatalf("expected resolved query text %q, got %q", query, resolvedText)
+	}
+}
+
+func TestPersistedQueryMiddlewareRejectsMismatchedHash(t *testing.T) {
+	store := NewInMemoryPersistedQueryStore(10)
+	mw := NewPersistedQueryMiddleware(store)
+
+	ext := &PersistedQueryExtensions{Sha256Hash: "not-the-real-hash"}
+	ctx := WithRequestText(context.Background(), `{ me { name } }`, ext)
+
+	_, err := mw(ctx, func(ctx context.Context) ([]*Resolved, error) {
+		t.Fatalf("next should not run when the hash doesn't match")
+		return nil, nil
+	})
+
+	if err == nil || !strings.Contains(err.Error(), ErrPersistedQueryMismatch.Error()) {
+		t.Fatalf("expected ErrPersistedQueryMismatch, got %v", err)
+	}
+}
@@ -21,9 +21,9 @@ import (
 	"context"
 	"io/ioutil"
 	"net/http"
+	"time"
 
 	"github.com/golang/glog"
-	otrace "go.opencensus.io/trace"
 
 	"github.com/dgraph-io/dgraph/gql"
 	"github.com/dgraph-io/dgraph/graphql/schema"
@@ -73,12 +73,53 @@ func (qe QueryExecutionFunc) Query(ctx context.Context, query *gql.GraphQuery) (
 	return qe(ctx, query)
 }
 
+// A QueryResolverOpt sets an optional behaviour of a queryResolver built by
+// NewQueryResolver.
+type QueryResolverOpt func(qr *queryResolver)
+
+// WithComplexityLimit rejects queries whose depth or complexity, as
+// computed against cfg, is too high, before they are ever rewritten to a
+// Dgraph query and shipped to the backend.
+func WithComplexityLimit(cfg *ComplexityConfig) QueryResolverOpt {
+	return func(qr *queryResolver) {
+		qr.complexity = cfg
+	}
+}
+
+// WithFieldMiddlewares chains mws around every query this resolver resolves,
+// mws[0] running outermost, so callers can plug in auth checks or
+// request-scoped logging without modifying queryResolver itself.
+func WithFieldMiddlewares(mws ...FieldMiddleware) QueryResolverOpt {
+	return func(qr *queryResolver) {
+		qr.fieldMiddlewares = append(qr.fieldMiddlewares, mws...)
+	}
+}
+
+// WithTracer replaces the default OpenTracing Tracer with t, so callers can
+// plug in Apollo Tracing or any other per-field timing collection.
+func WithTracer(t Tracer) QueryResolverOpt {
+	return func(qr *queryResolver) {
+		qr.tracer = t
+	}
+}
+
 // NewQueryResolver creates a new query resolver.  The resolver runs the pipeline:
 // 1) rewrite the query using qr (return error if failed)
 // 2) execute the rewritten query with qe (return error if failed)
 // 3) process the result with rc
-func NewQueryResolver(qr QueryRewriter, qe QueryExecutor, rc ResultCompleter) QueryResolver {
-	return &queryResolver{queryRewriter: qr, queryExecutor: qe, resultCompleter: rc}
+func NewQueryResolver(
+	qr QueryRewriter, qe QueryExecutor, rc ResultCompleter, opts ...QueryResolverOpt) QueryResolver {
+
+	resolver := &queryResolver{queryRewriter: qr, queryExecutor: qe, resultCompleter: rc}
+	for _, opt := range opts {
+		opt(resolver)
+	}
+	if resolver.tracer == nil {
+		resolver.tracer = NewOpenTracingTracer()
+	}
+	resolver.chain = chainFields(QueryResolverFunc(resolver.doResolve),
+		append([]FieldMiddleware{tracingMiddleware(resolver.tracer)}, resolver.fieldMiddlewares...))
+	return resolver
 }
 
 // NoOpQueryExecution does nothing and returns nil.
@@ -100,22 +141,72 @@ type queryResolver struct {
 	queryRewriter   QueryRewriter
 	queryExecutor   QueryExecutor
 	resultCompleter ResultCompleter
+
+	// complexity bounds the depth and complexity rewriteAndExecute will
+	// accept before rewriting a query.  It's nil unless WithComplexityLimit
+	// was passed to NewQueryResolver.
+	complexity *ComplexityConfig
+
+	// fieldMiddlewares and tracer are spliced, innermost first, around
+	// doResolve to build chain.  tracer defaults to NewOpenTracingTracer.
+	fieldMiddlewares []FieldMiddleware
+	tracer           Tracer
+	chain            QueryResolver
 }
 
+// Resolve runs query through qr's field middleware chain (tracing first,
+// then any middlewares passed to WithFieldMiddlewares), which ultimately
+// calls doResolve.
 func (qr *queryResolver) Resolve(ctx context.Context, query schema.Query) *Resolved {
-	span := otrace.FromContext(ctx)
-	stop := x.SpanTimer(span, "resolveQuery")
-	defer stop()
+	return qr.chain.Resolve(ctx, query)
+}
 
+// doResolve is the innermost link of qr's field middleware chain: it runs
+// the actual rewrite/execute/complete pipeline.
+func (qr *queryResolver) doResolve(ctx context.Context, query schema.Query) *Resolved {
 	res, err := qr.rewriteAndExecute(ctx, query)
 
 	completed, err := qr.resultCompleter.Complete(ctx, query, res, err)
 	return &Resolved{Data: completed, Err: err}
 }
 
+// rewriteForBatch rewrites query without executing it, so a
+// batchedResolverGroup can merge it with its sibling queries into a single
+// Dgraph query.  It makes queryResolver satisfy the unexported batchable
+// interface.
+func (qr *queryResolver) rewriteForBatch(
+	ctx context.Context, query schema.Query) (*gql.GraphQuery, error) {
+
+	if err := checkComplexity(qr.complexity, query); err != nil {
+		return nil, err
+	}
+	dgQuery, err := qr.queryRewriter.Rewrite(ctx, query)
+	if err != nil {
+		return nil, schema.GQLWrapf(err, "couldn't rewrite query %s", query.ResponseName())
+	}
+	return dgQuery, nil
+}
+
+// batchExecutor returns the QueryExecutor a batchedResolverGroup should use
+// to run the merged query this resolver's queries were folded into.
+func (qr *queryResolver) batchExecutor() QueryExecutor {
+	return qr.queryExecutor
+}
+
 func (qr *queryResolver) rewriteAndExecute(
 	ctx context.Context, query schema.Query) ([]byte, error) {
 
+	if err := checkComplexity(qr.complexity, query); err != nil {
+		return nil, err
+	}
+
+	// A batchedResolverGroup may have already executed this query as part
+	// of a merged Dgraph query; if so, use its share of that result instead
+	// of rewriting and running it a second time.
+	if resp, ok := batchResult(ctx, query.ResponseName()); ok {
+		return resp, nil
+	}
+
 	dgQuery, err := qr.queryRewriter.Rewrite(ctx, query)
 	if err != nil {
 		return nil, schema.GQLWrapf(err, "couldn't rewrite query %s", query.ResponseName())
@@ -142,20 +233,108 @@ type httpResolver struct {
 	httpRewriter    QueryRewriter
 	httpExecutor    QueryExecutor
 	resultCompleter ResultCompleter
+
+	// complexity bounds the depth and complexity rewriteAndExecute will
+	// accept before rewriting a query.  It's nil unless WithHTTPComplexityLimit
+	// was passed to NewHTTPResolver.
+	complexity *ComplexityConfig
+
+	// fieldMiddlewares and tracer are spliced, innermost first, around
+	// doResolve to build chain.  tracer defaults to NewOpenTracingTracer.
+	fieldMiddlewares []FieldMiddleware
+	tracer           Tracer
+	chain            QueryResolver
+
+	// dataSource configures header forwarding, retries and response mapping
+	// for the field's `@http` directive.  Nil means no header forwarding,
+	// no retries and no response mapping - the whole response body is used
+	// as-is, as it always was before WithHTTPDataSource existed.
+	dataSource *HTTPDataSourceConfig
+
+	// transport is used to build the *http.Client issuing the remote call
+	// when a non-default RoundTripper (e.g. mTLS or OpenCensus
+	// instrumented) is required.  Nil means hr.Client's own transport is
+	// used unchanged.
+	transport http.RoundTripper
+}
+
+// A HTTPResolverOpt sets an optional behaviour of a httpResolver built by
+// NewHTTPResolver.
+type HTTPResolverOpt func(hr *httpResolver)
+
+// WithHTTPComplexityLimit rejects queries whose depth or complexity, as
+// computed against cfg, is too high, before they are ever rewritten and
+// shipped off to the remote HTTP endpoint.
+func WithHTTPComplexityLimit(cfg *ComplexityConfig) HTTPResolverOpt {
+	return func(hr *httpResolver) {
+		hr.complexity = cfg
+	}
+}
+
+// WithHTTPFieldMiddlewares chains mws around every query this resolver
+// resolves, mws[0] running outermost.
+func WithHTTPFieldMiddlewares(mws ...FieldMiddleware) HTTPResolverOpt {
+	return func(hr *httpResolver) {
+		hr.fieldMiddlewares = append(hr.fieldMiddlewares, mws...)
+	}
+}
+
+// WithHTTPTracer replaces the default OpenTracing Tracer with t.
+func WithHTTPTracer(t Tracer) HTTPResolverOpt {
+	return func(hr *httpResolver) {
+		hr.tracer = t
+	}
+}
+
+// WithHTTPDataSource configures header forwarding, retries and response
+// mapping for the field's `@http` directive, as built by the schema layer
+// from the directive's arguments.
+func WithHTTPDataSource(cfg *HTTPDataSourceConfig) HTTPResolverOpt {
+	return func(hr *httpResolver) {
+		hr.dataSource = cfg
+	}
+}
+
+// WithHTTPTransport replaces the http.RoundTripper used to issue remote
+// calls, so callers can wire in mTLS or OpenCensus instrumented transports.
+func WithHTTPTransport(transport http.RoundTripper) HTTPResolverOpt {
+	return func(hr *httpResolver) {
+		hr.transport = transport
+	}
 }
 
 func NewHTTPResolver(hc *http.Client,
 	qr QueryRewriter,
 	qe QueryExecutor,
-	rc ResultCompleter) QueryResolver {
-	return &httpResolver{hc, qr, qe, rc}
+	rc ResultCompleter,
+	opts ...HTTPResolverOpt) QueryResolver {
+
+	resolver := &httpResolver{Client: hc, httpRewriter: qr, httpExecutor: qe, resultCompleter: rc}
+	for _, opt := range opts {
+		opt(resolver)
+	}
+	if resolver.tracer == nil {
+		resolver.tracer = NewOpenTracingTracer()
+	}
+	if resolver.transport != nil {
+		client := *hc
+		client.Transport = resolver.transport
+		resolver.Client = &client
+	}
+	resolver.chain = chainFields(QueryResolverFunc(resolver.doResolve),
+		append([]FieldMiddleware{tracingMiddleware(resolver.tracer)}, resolver.fieldMiddlewares...))
+	return resolver
 }
 
+// Resolve runs query through hr's field middleware chain, which ultimately
+// calls doResolve.
 func (hr *httpResolver) Resolve(ctx context.Context, query schema.Query) *Resolved {
-	span := otrace.FromContext(ctx)
-	stop := x.SpanTimer(span, "resolveHTTPQuery")
-	defer stop()
+	return hr.chain.Resolve(ctx, query)
+}
 
+// doResolve is the innermost link of hr's field middleware chain: it runs
+// the actual rewrite/execute/complete pipeline against the remote endpoint.
+func (hr *httpResolver) doResolve(ctx context.Context, query schema.Query) *Resolved {
 	res, err := hr.rewriteAndExecute(ctx, query)
 
 	completed, err := hr.resultCompleter.Complete(ctx, query, res, err)
@@ -164,21 +343,109 @@ func (hr *httpResolver) Resolve(ctx context.Context, query schema.Query) *Resolv
 
 func (hr *httpResolver) rewriteAndExecute(
 	ctx context.Context, query schema.Query) ([]byte, error) {
+
+	if err := checkComplexity(hr.complexity, query); err != nil {
+		return nil, err
+	}
+
 	hrc, err := query.HTTPResolver()
 	if err != nil {
 		return nil, err
 	}
-	req, err := http.NewRequest(hrc.Method, hrc.URL, bytes.NewBufferString(hrc.Body))
+
+	url, err := renderTemplate(ctx, query, hrc.URL, templateKindURL)
 	if err != nil {
 		return nil, err
 	}
-
-	resp, err := hr.Do(req)
+	body, err := renderTemplate(ctx, query, hrc.Body, templateKindJSON)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
 
-	b, err := ioutil.ReadAll(resp.Body)
-	return b, err
+	b, err := hr.doWithRetry(ctx, hrc.Method, url, body)
+	if err != nil {
+		return nil, schema.GQLWrapf(err, "%s request to %s failed", hrc.Method, url)
+	}
+
+	return applyResponseMapping(hr.dataSource, b), nil
+}
+
+// doWithRetry builds the outgoing request - applying forwarded and static
+// headers from hr.dataSource - and issues it, retrying on 5xx responses and
+// network errors according to hr.dataSource.Retry.
+func (hr *httpResolver) doWithRetry(
+	ctx context.Context, method, url, body string) ([]byte, error) {
+
+	var retry RetryPolicy
+	if hr.dataSource != nil {
+		retry = hr.dataSource.Retry
+	}
+
+	var lastErr error
+	for attempt := 0; attempt <= retry.MaxRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(retry.backoff(attempt - 1)):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+
+		attemptCtx := ctx
+		cancel := func() {}
+		if hr.dataSource != nil && hr.dataSource.Timeout > 0 {
+			attemptCtx, cancel = context.WithTimeout(ctx, hr.dataSource.Timeout)
+		}
+
+		req, err := http.NewRequestWithContext(attemptCtx, method, url, bytes.NewBufferString(body))
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		hr.addHeaders(ctx, req)
+
+		resp, err := hr.Do(req)
+		if err != nil {
+			cancel()
+			lastErr = err
+			continue
+		}
+
+		b, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		cancel()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		if resp.StatusCode >= 500 {
+			lastErr = x.GqlErrorf("remote server returned %s", resp.Status)
+			continue
+		}
+
+		return b, nil
+	}
+
+	return nil, lastErr
+}
+
+// addHeaders copies hr.dataSource's ForwardHeaders from the original
+// inbound request (see WithInboundHeaders) and sets its static Headers on
+// req, e.g. a remote service's Authorization header.
+func (hr *httpResolver) addHeaders(ctx context.Context, req *http.Request) {
+	if hr.dataSource == nil {
+		return
+	}
+
+	inbound := inboundHeaders(ctx)
+	for _, name := range hr.dataSource.ForwardHeaders {
+		if vals, ok := inbound[name]; ok {
+			req.Header[name] = vals
+		}
+	}
+
+	for name, val := range hr.dataSource.Headers {
+		req.Header.Set(name, val)
+	}
 }
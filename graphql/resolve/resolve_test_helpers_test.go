@@ -0,0 +1,92 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package resolve
+
+import (
+	"context"
+
+	"github.com/dgraph-io/dgraph/gql"
+	"github.com/dgraph-io/dgraph/graphql/schema"
+)
+
+// fakeQuery is a minimal schema.Query used across this package's tests, so
+// each test doesn't need a real parsed GraphQL schema to exercise the
+// resolve pipeline.
+type fakeQuery struct {
+	name     string
+	alias    string
+	args     map[string]interface{}
+	children []schema.Field
+	hrc      *schema.HTTPResolverConfig
+	hrcErr   error
+}
+
+func (q *fakeQuery) Name() string { return q.name }
+func (q *fakeQuery) ResponseName() string {
+	if q.alias != "" {
+		return q.alias
+	}
+	return q.name
+}
+func (q *fakeQuery) Arguments() map[string]interface{} { return q.args }
+func (q *fakeQuery) SelectionSet() []schema.Field      { return q.children }
+func (q *fakeQuery) HTTPResolver() (*schema.HTTPResolverConfig, error) {
+	return q.hrc, q.hrcErr
+}
+
+// fakeRewriter rewrites every query to a fixed, or per-query, gql.GraphQuery.
+type fakeRewriter struct {
+	rewrite func(ctx context.Context, q schema.Query) (*gql.GraphQuery, error)
+}
+
+func (r *fakeRewriter) Rewrite(ctx context.Context, q schema.Query) (*gql.GraphQuery, error) {
+	if r.rewrite != nil {
+		return r.rewrite(ctx, q)
+	}
+	return &gql.GraphQuery{Attr: q.(*fakeQuery).name}, nil
+}
+
+// fakeExecutor records every query it's asked to run and returns a
+// pre-configured response.
+type fakeExecutor struct {
+	calls    int
+	response []byte
+	err      error
+}
+
+func (e *fakeExecutor) Query(ctx context.Context, query *gql.GraphQuery) ([]byte, error) {
+	e.calls++
+	return e.response, e.err
+}
+
+// fakeCompleter returns the raw bytes it was given, untouched, unless told
+// to fail.
+type fakeCompleter struct {
+	err error
+}
+
+func (c *fakeCompleter) Complete(
+	ctx context.Context, query schema.Query, result []byte, err error) ([]byte, error) {
+
+	if err != nil {
+		return nil, err
+	}
+	if c.err != nil {
+		return nil, c.err
+	}
+	return result, nil
+}
@@ -0,0 +1,173 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package resolve
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/dgraph-io/dgraph/gql"
+	"github.com/dgraph-io/dgraph/graphql/schema"
+	"github.com/dgraph-io/dgraph/protos/pb"
+)
+
+// A SubscriptionResolver can resolve a GraphQL subscription field, streaming
+// a new *Resolved every time the underlying data changes, until ctx is
+// cancelled (the client sent `stop`/`connection_terminate`, or the
+// connection dropped).
+type SubscriptionResolver interface {
+	Resolve(ctx context.Context, subscription schema.Query) (<-chan *Resolved, error)
+}
+
+// A ChangeStream gives access to Dgraph's change-data-capture/Raft proposal
+// stream, so a subscriptionResolver can know when it might need to
+// re-evaluate a running subscription.
+type ChangeStream interface {
+	// Subscribe returns a channel of proposals applied after Subscribe was
+	// called.  The channel is closed when ctx is done.
+	Subscribe(ctx context.Context) (<-chan *pb.Proposal, error)
+}
+
+// NewSubscriptionResolver creates a SubscriptionResolver that re-runs a
+// subscription's rewritten query every time stream reports a proposal that
+// touched one of the predicates the query selects, and emits a new
+// *Resolved only when the completed result actually differs from the last
+// one sent.
+func NewSubscriptionResolver(
+	qr QueryRewriter, qe QueryExecutor, rc ResultCompleter, stream ChangeStream) SubscriptionResolver {
+
+	return &subscriptionResolver{
+		queryRewriter:   qr,
+		queryExecutor:   qe,
+		resultCompleter: rc,
+		stream:          stream,
+	}
+}
+
+type subscriptionResolver struct {
+	queryRewriter   QueryRewriter
+	queryExecutor   QueryExecutor
+	resultCompleter ResultCompleter
+	stream          ChangeStream
+}
+
+func (sr *subscriptionResolver) Resolve(
+	ctx context.Context, subscription schema.Query) (<-chan *Resolved, error) {
+
+	dgQuery, err := sr.queryRewriter.Rewrite(ctx, subscription)
+	if err != nil {
+		return nil, schema.GQLWrapf(err, "couldn't rewrite subscription %s", subscription.ResponseName())
+	}
+	predicates := predicatesUsed(dgQuery)
+
+	proposals, err := sr.stream.Subscribe(ctx)
+	if err != nil {
+		return nil, schema.GQLWrapf(err, "couldn't subscribe to Dgraph's proposal stream")
+	}
+
+	out := make(chan *Resolved, 1)
+	initial, err := sr.evaluate(ctx, subscription, dgQuery)
+	if err != nil {
+		return nil, err
+	}
+	out <- initial
+	last := initial.Data
+
+	go func() {
+		defer close(out)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case p, ok := <-proposals:
+				if !ok {
+					return
+				}
+				if !touchesAny(p, predicates) {
+					continue
+				}
+
+				resolved, err := sr.evaluate(ctx, subscription, dgQuery)
+				if err != nil {
+					select {
+					case out <- &Resolved{Err: err}:
+					case <-ctx.Done():
+						return
+					}
+					continue
+				}
+				if bytes.Equal(resolved.Data, last) {
+					continue
+				}
+				last = resolved.Data
+				select {
+				case out <- resolved:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+func (sr *subscriptionResolver) evaluate(
+	ctx context.Context, subscription schema.Query, dgQuery *gql.GraphQuery) (*Resolved, error) {
+
+	resp, err := sr.queryExecutor.Query(ctx, dgQuery)
+	if err != nil {
+		resp, err = nil, schema.GQLWrapf(err, "Dgraph query failed")
+	}
+	completed, err := sr.resultCompleter.Complete(ctx, subscription, resp, err)
+	return &Resolved{Data: completed, Err: err}, nil
+}
+
+// predicatesUsed collects the predicate names referenced by query and its
+// children, so that proposals touching unrelated predicates can be skipped
+// without re-running the subscription.
+func predicatesUsed(query *gql.GraphQuery) map[string]bool {
+	preds := make(map[string]bool)
+	collectPredicates(query, preds)
+	return preds
+}
+
+func collectPredicates(query *gql.GraphQuery, preds map[string]bool) {
+	if query == nil {
+		return
+	}
+	if query.Attr != "" {
+		preds[query.Attr] = true
+	}
+	for _, child := range query.Children {
+		collectPredicates(child, preds)
+	}
+}
+
+// touchesAny reports whether proposal p mutated any of the predicates in
+// preds.
+func touchesAny(p *pb.Proposal, preds map[string]bool) bool {
+	if p == nil || p.Mutations == nil {
+		return false
+	}
+	for _, edge := range p.Mutations.Edges {
+		if preds[edge.Attr] {
+			return true
+		}
+	}
+	return false
+}
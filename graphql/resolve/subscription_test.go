@@ -0,0 +1,110 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package resolve
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/dgraph/gql"
+	"github.com/dgraph-io/dgraph/graphql/schema"
+	"github.com/dgraph-io/dgraph/protos/pb"
+)
+
+type fakeChangeStream struct {
+	proposals chan *pb.Proposal
+}
+
+func (s *fakeChangeStream) Subscribe(ctx context.Context) (<-chan *pb.Proposal, error) {
+	return s.proposals, nil
+}
+
+type countingExecutor struct {
+	n int
+}
+
+func (e *countingExecutor) Query(ctx context.Context, query *gql.GraphQuery) ([]byte, error) {
+	e.n++
+	return []byte(fmt.Sprintf(`{"n":%d}`, e.n)), nil
+}
+
+// recvWithTimeout reads from ch, failing the test if nothing arrives within
+// timeout, so a goroutine that leaks (never sends, never closes) doesn't
+// hang the test suite.
+func recvWithTimeout(t *testing.T, ch <-chan *Resolved, timeout time.Duration) (*Resolved, bool) {
+	t.Helper()
+	select {
+	case v, ok := <-ch:
+		return v, ok
+	case <-time.After(timeout):
+		t.Fatalf("timed out waiting to receive from channel")
+		return nil, false
+	}
+}
+
+// TestSubscriptionResolverStopsOnContextCancelEvenWhenConsumerStalled is a
+// regression test for a goroutine/ChangeStream leak: the update loop used to
+// send on out outside of its select, so a stalled consumer (out's buffer of
+// 1 already full) left the goroutine unable to ever observe ctx.Done().
+func TestSubscriptionResolverStopsOnContextCancelEvenWhenConsumerStalled(t *testing.T) {
+	proposals := make(chan *pb.Proposal)
+	stream := &fakeChangeStream{proposals: proposals}
+	executor := &countingExecutor{}
+	rewriter := &fakeRewriter{
+		rewrite: func(ctx context.Context, q schema.Query) (*gql.GraphQuery, error) {
+			return &gql.GraphQuery{Attr: "name"}, nil
+		},
+	}
+
+	resolver := NewSubscriptionResolver(rewriter, executor, &fakeCompleter{}, stream)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	out, err := resolver.Resolve(ctx, &fakeQuery{name: "q"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// Drain the initial value so out's buffer of 1 is empty.
+	recvWithTimeout(t, out, time.Second)
+
+	touching := &pb.Proposal{Mutations: &pb.Mutations{Edges: []*pb.DirectedEdge{{Attr: "name"}}}}
+
+	// This fills out's buffer of 1 - we deliberately don't read it, to
+	// simulate a stalled consumer (e.g. a slow WebSocket write).
+	proposals <- touching
+
+	// This evaluate's result also differs from the last one sent, so the
+	// update loop will try (and, before the fix, be stuck) sending it on
+	// out while the buffer is still full.
+	proposals <- touching
+
+	cancel()
+
+	// The still-buffered first update should still be readable...
+	if _, ok := recvWithTimeout(t, out, time.Second); !ok {
+		t.Fatalf("expected the buffered update, got a closed channel")
+	}
+	// ...and the goroutine should have noticed ctx.Done() and closed out,
+	// rather than staying blocked forever on the second send.
+	if _, ok := recvWithTimeout(t, out, time.Second); ok {
+		t.Fatalf("expected out to be closed after ctx was cancelled")
+	}
+}
@@ -0,0 +1,299 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package resolve
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/golang/glog"
+
+	"github.com/dgraph-io/dgraph/graphql/schema"
+)
+
+// The message types of the `graphql-ws` (subscriptions-transport-ws)
+// protocol that WebSocketHandler speaks.
+const (
+	gqlConnectionInit      = "connection_init"
+	gqlConnectionAck       = "connection_ack"
+	gqlConnectionError     = "connection_error"
+	gqlConnectionKeepAlive = "ka"
+	gqlConnectionTerminate = "connection_terminate"
+	gqlStart               = "start"
+	gqlData                = "data"
+	gqlError               = "error"
+	gqlComplete            = "complete"
+	gqlStop                = "stop"
+)
+
+// InitPayload is the (application defined) payload a client sends with its
+// `connection_init` message - typically an auth token.
+type InitPayload map[string]interface{}
+
+type initPayloadCtxKey struct{}
+
+// WithInitPayload returns a context carrying payload.
+func WithInitPayload(ctx context.Context, payload InitPayload) context.Context {
+	return context.WithValue(ctx, initPayloadCtxKey{}, payload)
+}
+
+// GetInitPayload returns the InitPayload placed on ctx by WithInitPayload,
+// or nil if there isn't one.
+func GetInitPayload(ctx context.Context) InitPayload {
+	p, _ := ctx.Value(initPayloadCtxKey{}).(InitPayload)
+	return p
+}
+
+type wsMessage struct {
+	ID      string          `json:"id,omitempty"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+type startPayload struct {
+	Query         string                 `json:"query"`
+	Variables     map[string]interface{} `json:"variables"`
+	OperationName string                 `json:"operationName"`
+}
+
+// WebSocketConfig bounds the resource usage of a WebSocketHandler.
+type WebSocketConfig struct {
+	// PingInterval is how often a `ka` (keep-alive) message is sent to the
+	// client; one is sent on every tick, whether or not the connection is
+	// idle.
+	PingInterval time.Duration
+	// MaxConcurrentSubscriptions is the number of `start`ed subscriptions a
+	// single connection may have running at once; further `start`s get a
+	// GQL_ERROR response instead of being run.
+	MaxConcurrentSubscriptions int
+	// CheckOrigin decides whether to accept a WebSocket handshake given its
+	// originating request. Nil means gorilla/websocket's default same-origin
+	// check. Use AllowAnyOrigin to accept every origin, e.g. when auth is
+	// carried entirely in InitPayload rather than ambient cookies.
+	CheckOrigin func(r *http.Request) bool
+}
+
+// AllowAnyOrigin is a WebSocketConfig.CheckOrigin that accepts every origin.
+func AllowAnyOrigin(r *http.Request) bool {
+	return true
+}
+
+// A SubscriptionParser builds the schema.Query a subscription resolves for,
+// from the query/variables/operationName of a `start` message.
+type SubscriptionParser func(
+	ctx context.Context, query string, variables map[string]interface{}, operationName string,
+) (schema.Query, error)
+
+// NewSubscriptionHandler builds an http.Handler that upgrades incoming
+// requests to WebSocket connections and speaks the `graphql-ws` protocol
+// against resolver, parsing each `start` message's query with parse.
+func NewSubscriptionHandler(
+	resolver SubscriptionResolver, parse SubscriptionParser, cfg WebSocketConfig) http.Handler {
+
+	if cfg.PingInterval <= 0 {
+		cfg.PingInterval = 20 * time.Second
+	}
+	if cfg.MaxConcurrentSubscriptions <= 0 {
+		cfg.MaxConcurrentSubscriptions = 100
+	}
+
+	upgrader := websocket.Upgrader{
+		Subprotocols:    []string{"graphql-ws"},
+		CheckOrigin:     cfg.CheckOrigin,
+		ReadBufferSize:  4096,
+		WriteBufferSize: 4096,
+	}
+
+	return &wsHandler{resolver: resolver, parse: parse, cfg: cfg, upgrader: upgrader}
+}
+
+type wsHandler struct {
+	resolver SubscriptionResolver
+	parse    SubscriptionParser
+	cfg      WebSocketConfig
+	upgrader websocket.Upgrader
+}
+
+func (h *wsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		glog.Errorf("couldn't upgrade GraphQL subscription connection: %s", err)
+		return
+	}
+	defer conn.Close()
+
+	c := &wsConnection{
+		conn:     conn,
+		resolver: h.resolver,
+		parse:    h.parse,
+		sem:      make(chan struct{}, h.cfg.MaxConcurrentSubscriptions),
+		cancels:  make(map[string]context.CancelFunc),
+	}
+	c.serve(h.cfg.PingInterval)
+}
+
+// wsConnection is the per-connection state for one client: the
+// subscriptions it has `start`ed, and the semaphore bounding how many of
+// them may run concurrently.
+type wsConnection struct {
+	conn     *websocket.Conn
+	resolver SubscriptionResolver
+	parse    SubscriptionParser
+	sem      chan struct{}
+
+	mu      sync.Mutex
+	ctx     context.Context
+	cancels map[string]context.CancelFunc
+}
+
+func (c *wsConnection) serve(pingInterval time.Duration) {
+	ctx, cancel := context.WithCancel(context.Background())
+	c.ctx = ctx
+	defer cancel()
+
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+	go func() {
+		for range ticker.C {
+			if c.writeJSON(wsMessage{Type: gqlConnectionKeepAlive}) != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		var msg wsMessage
+		if err := c.conn.ReadJSON(&msg); err != nil {
+			c.stopAll()
+			return
+		}
+
+		switch msg.Type {
+		case gqlConnectionInit:
+			var payload InitPayload
+			_ = json.Unmarshal(msg.Payload, &payload)
+			c.ctx = WithInitPayload(ctx, payload)
+			_ = c.writeJSON(wsMessage{Type: gqlConnectionAck})
+
+		case gqlStart:
+			c.start(msg.ID, msg.Payload)
+
+		case gqlStop:
+			c.stop(msg.ID)
+
+		case gqlConnectionTerminate:
+			c.stopAll()
+			return
+		}
+	}
+}
+
+func (c *wsConnection) start(id string, rawPayload json.RawMessage) {
+	select {
+	case c.sem <- struct{}{}:
+	default:
+		_ = c.writeJSON(wsMessage{ID: id, Type: gqlError,
+			Payload: mustMarshal(map[string]string{"message": "too many concurrent subscriptions"})})
+		return
+	}
+
+	var payload startPayload
+	if err := json.Unmarshal(rawPayload, &payload); err != nil {
+		<-c.sem
+		_ = c.writeJSON(wsMessage{ID: id, Type: gqlError,
+			Payload: mustMarshal(map[string]string{"message": err.Error()})})
+		return
+	}
+
+	subCtx, cancel := context.WithCancel(c.ctx)
+	c.mu.Lock()
+	c.cancels[id] = cancel
+	c.mu.Unlock()
+
+	subscription, err := c.parse(subCtx, payload.Query, payload.Variables, payload.OperationName)
+	if err != nil {
+		<-c.sem
+		_ = c.writeJSON(wsMessage{ID: id, Type: gqlError,
+			Payload: mustMarshal(map[string]string{"message": err.Error()})})
+		return
+	}
+
+	updates, err := c.resolver.Resolve(subCtx, subscription)
+	if err != nil {
+		<-c.sem
+		_ = c.writeJSON(wsMessage{ID: id, Type: gqlError,
+			Payload: mustMarshal(map[string]string{"message": err.Error()})})
+		return
+	}
+
+	go func() {
+		defer func() {
+			<-c.sem
+			c.mu.Lock()
+			delete(c.cancels, id)
+			c.mu.Unlock()
+			cancel()
+		}()
+		for resolved := range updates {
+			if resolved.Err != nil {
+				_ = c.writeJSON(wsMessage{ID: id, Type: gqlError,
+					Payload: mustMarshal(map[string]string{"message": resolved.Err.Error()})})
+				continue
+			}
+			_ = c.writeJSON(wsMessage{ID: id, Type: gqlData, Payload: resolved.Data})
+		}
+		_ = c.writeJSON(wsMessage{ID: id, Type: gqlComplete})
+	}()
+}
+
+func (c *wsConnection) stop(id string) {
+	c.mu.Lock()
+	cancel, ok := c.cancels[id]
+	delete(c.cancels, id)
+	c.mu.Unlock()
+	if ok {
+		cancel()
+	}
+}
+
+func (c *wsConnection) stopAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for id, cancel := range c.cancels {
+		cancel()
+		delete(c.cancels, id)
+	}
+}
+
+func (c *wsConnection) writeJSON(msg wsMessage) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn.WriteJSON(msg)
+}
+
+func mustMarshal(v interface{}) json.RawMessage {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return json.RawMessage(`{}`)
+	}
+	return b
+}
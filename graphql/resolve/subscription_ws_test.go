@@ -0,0 +1,133 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package resolve
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/dgraph-io/dgraph/graphql/schema"
+)
+
+type fakeSubscriptionResolver struct {
+	updates chan *Resolved
+}
+
+func (r *fakeSubscriptionResolver) Resolve(
+	ctx context.Context, subscription schema.Query) (<-chan *Resolved, error) {
+	go func() {
+		<-ctx.Done()
+		close(r.updates)
+	}()
+	return r.updates, nil
+}
+
+func dialWS(t *testing.T, srv *httptest.Server) *websocket.Conn {
+	t.Helper()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("couldn't dial %s: %s", url, err)
+	}
+	return conn
+}
+
+func readMessage(t *testing.T, conn *websocket.Conn) wsMessage {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var msg wsMessage
+	if err := conn.ReadJSON(&msg); err != nil {
+		t.Fatalf("didn't receive a message: %s", err)
+	}
+	return msg
+}
+
+// TestSubscriptionHandlerStartStop drives a real WebSocket connection
+// through connection_init, start and stop, and checks the graphql-ws
+// message sequence a client would see.
+func TestSubscriptionHandlerStartStop(t *testing.T) {
+	updates := make(chan *Resolved, 1)
+	resolver := &fakeSubscriptionResolver{updates: updates}
+	parse := SubscriptionParser(func(
+		ctx context.Context, query string, variables map[string]interface{}, operationName string,
+	) (schema.Query, error) {
+		return &fakeQuery{name: "q"}, nil
+	})
+
+	handler := NewSubscriptionHandler(resolver, parse, WebSocketConfig{CheckOrigin: AllowAnyOrigin})
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	conn := dialWS(t, srv)
+	defer conn.Close()
+
+	if err := conn.WriteJSON(wsMessage{Type: gqlConnectionInit}); err != nil {
+		t.Fatalf("couldn't send connection_init: %s", err)
+	}
+	if msg := readMessage(t, conn); msg.Type != gqlConnectionAck {
+		t.Fatalf("expected connection_ack, got %s", msg.Type)
+	}
+
+	if err := conn.WriteJSON(wsMessage{ID: "1", Type: gqlStart, Payload: mustMarshal(startPayload{Query: "{ q }"})}); err != nil {
+		t.Fatalf("couldn't send start: %s", err)
+	}
+
+	updates <- &Resolved{Data: []byte(`{"q":1}`)}
+	if msg := readMessage(t, conn); msg.Type != gqlData || msg.ID != "1" {
+		t.Fatalf("expected data for subscription 1, got %+v", msg)
+	}
+
+	if err := conn.WriteJSON(wsMessage{ID: "1", Type: gqlStop}); err != nil {
+		t.Fatalf("couldn't send stop: %s", err)
+	}
+	if msg := readMessage(t, conn); msg.Type != gqlComplete || msg.ID != "1" {
+		t.Fatalf("expected complete for subscription 1, got %+v", msg)
+	}
+}
+
+// TestSubscriptionHandlerMaxConcurrentSubscriptions checks that a `start`
+// past the configured limit gets a GQL_ERROR instead of being run.
+func TestSubscriptionHandlerMaxConcurrentSubscriptions(t *testing.T) {
+	resolver := &fakeSubscriptionResolver{updates: make(chan *Resolved)}
+	parse := SubscriptionParser(func(
+		ctx context.Context, query string, variables map[string]interface{}, operationName string,
+	) (schema.Query, error) {
+		return &fakeQuery{name: "q"}, nil
+	})
+
+	handler := NewSubscriptionHandler(resolver, parse, WebSocketConfig{
+		CheckOrigin:                AllowAnyOrigin,
+		MaxConcurrentSubscriptions: 1,
+	})
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	conn := dialWS(t, srv)
+	defer conn.Close()
+
+	conn.WriteJSON(wsMessage{ID: "1", Type: gqlStart, Payload: mustMarshal(startPayload{Query: "{ q }"})})
+	conn.WriteJSON(wsMessage{ID: "2", Type: gqlStart, Payload: mustMarshal(startPayload{Query: "{ q }"})})
+
+	if msg := readMessage(t, conn); msg.Type != gqlError || msg.ID != "2" {
+		t.Fatalf("expected the second start to be rejected with an error, got %+v", msg)
+	}
+}